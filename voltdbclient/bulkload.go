@@ -0,0 +1,272 @@
+/* This file is part of VoltDB.
+ * Copyright (C) 2008-2016 VoltDB Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with VoltDB.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package voltdbclient
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// defaultBatchSize is how many rows VoltBulkLoader buffers before shipping
+// a batch, when BulkLoaderOptions.BatchSize is left at zero.
+const defaultBatchSize = 200
+
+// defaultMaxOutstandingBatches bounds how many batches can be in flight
+// before WriteRow blocks, when BulkLoaderOptions.MaxOutstandingBatches is
+// left at zero.
+const defaultMaxOutstandingBatches = 5
+
+// BulkLoaderOptions configures a VoltBulkLoader.
+type BulkLoaderOptions struct {
+	// BatchSize is the number of rows buffered per call to the load
+	// procedure. Defaults to defaultBatchSize.
+	BatchSize int
+
+	// MaxOutstandingBatches bounds how many batches may be in flight at
+	// once; WriteRow blocks once this many are outstanding, providing
+	// back-pressure against a slow server. Defaults to
+	// defaultMaxOutstandingBatches.
+	MaxOutstandingBatches int
+
+	// Multipartition selects @LoadMultipartitionTable instead of
+	// @LoadSinglepartitionTable.
+	Multipartition bool
+
+	// Upsert loads rows with upsert (replace-on-conflict) semantics
+	// instead of plain insert.
+	Upsert bool
+}
+
+// RowFailure describes a single row that the load procedure rejected.
+type RowFailure struct {
+	RowIndex int
+	Status   responseStatus
+	Message  string
+}
+
+// LoadTableError carries the per-row status VoltDB's server response
+// actually reported for a batch, letting loadBatch report each row's real
+// cause instead of a single generic failure for the whole batch. Nothing in
+// this client parses a server response into one yet -- that requires the
+// ClientResponse/VoltTable wire parser, which doesn't exist in this tree
+// (see conn.go's missing NetworkListener/VoltStatement internals) -- but
+// loadBatch already unwraps a load procedure's Exec error via errors.As, so
+// a future response parser only needs to produce/wrap this type to have its
+// per-row detail surface on Failures() automatically.
+type LoadTableError struct {
+	Rows []RowFailure
+}
+
+func (e *LoadTableError) Error() string {
+	return fmt.Sprintf("voltdbclient: load failed for %d row(s)", len(e.Rows))
+}
+
+// VoltBulkLoader batches rows written with WriteRow and ships them through
+// VoltDB's bulk-load procedures, the Go equivalent of looping over Exec
+// except hundreds of rows at a time. It is modeled on lib/pq's CopyIn: call
+// WriteRow repeatedly, then Close (or Exec with no args, for lib/pq
+// parity) to flush and finish.
+//
+// NOT CURRENTLY FUNCTIONAL against a real VoltDB server: each batch is
+// serialized by encodeVoltTable using a placeholder row encoding, not
+// VoltDB's actual VoltTable wire format (see encodeVoltTable's own doc
+// comment in volttable.go for why), so @LoadSinglepartitionTable/
+// @LoadMultipartitionTable cannot parse what gets sent. Per-row failure
+// detail via LoadTableError is similarly aspirational -- nothing in this
+// tree constructs one, because that requires parsing a real
+// ClientResponse, which needs wire-protocol internals (see conn.go's
+// missing NetworkListener/VoltStatement) that don't exist in this
+// snapshot. Every failure currently surfaces through reportBatchFailure's
+// synthesized statusUnexpectedFailure instead. Treat this type as a
+// skeleton for the real encoder/response-parser pair, not a usable loader,
+// until both land.
+type VoltBulkLoader struct {
+	conn  *VoltConn
+	table string
+	opts  BulkLoaderOptions
+
+	mu       sync.Mutex
+	batch    [][]driver.Value
+	rowIndex int
+	closed   bool
+
+	outstanding chan struct{} // one token held per in-flight batch
+	wg          sync.WaitGroup
+
+	failures chan RowFailure
+}
+
+// BulkLoader returns a VoltBulkLoader that streams rows into table. See
+// VoltBulkLoader's doc comment: this is not yet wire-compatible with a real
+// VoltDB server.
+func (vc *VoltConn) BulkLoader(table string, opts BulkLoaderOptions) (*VoltBulkLoader, error) {
+	if table == "" {
+		return nil, errors.New("voltdbclient: BulkLoader requires a table name")
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultBatchSize
+	}
+	if opts.MaxOutstandingBatches <= 0 {
+		opts.MaxOutstandingBatches = defaultMaxOutstandingBatches
+	}
+
+	return &VoltBulkLoader{
+		conn:        vc,
+		table:       table,
+		opts:        opts,
+		outstanding: make(chan struct{}, opts.MaxOutstandingBatches),
+		failures:    make(chan RowFailure, opts.MaxOutstandingBatches*opts.BatchSize),
+	}, nil
+}
+
+// Failures returns the channel per-row failures are published on. It is
+// closed once Close has flushed and every outstanding batch has completed.
+func (bl *VoltBulkLoader) Failures() <-chan RowFailure {
+	return bl.failures
+}
+
+// WriteRow buffers one row of cols, flushing the current batch once it
+// reaches BatchSize. It blocks if MaxOutstandingBatches batches are
+// already in flight.
+func (bl *VoltBulkLoader) WriteRow(cols ...driver.Value) error {
+	bl.mu.Lock()
+	if bl.closed {
+		bl.mu.Unlock()
+		return errors.New("voltdbclient: WriteRow called on a closed VoltBulkLoader")
+	}
+	bl.batch = append(bl.batch, cols)
+	full := len(bl.batch) >= bl.opts.BatchSize
+	bl.mu.Unlock()
+
+	if full {
+		return bl.Flush()
+	}
+	return nil
+}
+
+// Flush ships whatever rows are currently buffered, even if the batch
+// isn't full. It blocks for a free outstanding-batch slot, not for the
+// batch to complete; per-row failures surface on Failures().
+func (bl *VoltBulkLoader) Flush() error {
+	bl.mu.Lock()
+	if bl.closed {
+		bl.mu.Unlock()
+		return errors.New("voltdbclient: Flush called on a closed VoltBulkLoader")
+	}
+	batch := bl.batch
+	bl.batch = nil
+	startIndex := bl.rowIndex
+	bl.rowIndex += len(batch)
+	bl.mu.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+
+	bl.outstanding <- struct{}{}
+	bl.wg.Add(1)
+	go bl.loadBatch(startIndex, batch)
+	return nil
+}
+
+func (bl *VoltBulkLoader) loadBatch(startIndex int, batch [][]driver.Value) {
+	defer bl.wg.Done()
+	defer func() { <-bl.outstanding }()
+
+	procName := "@LoadSinglepartitionTable"
+	if bl.opts.Multipartition {
+		procName = "@LoadMultipartitionTable"
+	}
+
+	stmt, err := bl.conn.Prepare(procName)
+	if err != nil {
+		bl.reportBatchFailure(startIndex, len(batch), err)
+		return
+	}
+	defer stmt.Close()
+
+	table, err := encodeVoltTable(batch)
+	if err != nil {
+		bl.reportBatchFailure(startIndex, len(batch), err)
+		return
+	}
+
+	// @Load{Single,Multi}partitionTable takes exactly three arguments:
+	// the table name, the upsert flag, and the VoltTable payload for the
+	// whole batch -- one RPC per batch, not one per row.
+	if _, err := stmt.Exec([]driver.Value{bl.table, bl.opts.Upsert, table}); err != nil {
+		var lte *LoadTableError
+		if errors.As(err, &lte) {
+			for _, rf := range lte.Rows {
+				rf.RowIndex += startIndex
+				bl.failures <- rf
+			}
+			return
+		}
+		bl.reportBatchFailure(startIndex, len(batch), err)
+	}
+}
+
+func (bl *VoltBulkLoader) reportBatchFailure(startIndex, n int, err error) {
+	for i := 0; i < n; i++ {
+		bl.failures <- RowFailure{
+			RowIndex: startIndex + i,
+			Status:   statusUnexpectedFailure,
+			Message:  fmt.Sprintf("batch failed: %v", err),
+		}
+	}
+}
+
+// Close flushes any buffered rows, waits for every outstanding batch to
+// complete, and closes the Failures channel. A subsequent Exec with no
+// args (matching lib/pq's CopyIn convention) is equivalent to calling
+// Close directly.
+func (bl *VoltBulkLoader) Close() error {
+	bl.mu.Lock()
+	if bl.closed {
+		bl.mu.Unlock()
+		return nil
+	}
+	bl.closed = true
+	batch := bl.batch
+	bl.batch = nil
+	startIndex := bl.rowIndex
+	bl.rowIndex += len(batch)
+	bl.mu.Unlock()
+
+	if len(batch) > 0 {
+		bl.outstanding <- struct{}{}
+		bl.wg.Add(1)
+		go bl.loadBatch(startIndex, batch)
+	}
+	bl.wg.Wait()
+	close(bl.failures)
+	return nil
+}
+
+// Exec with no arguments flushes and closes the loader, mirroring the
+// lib/pq convention where a CopyIn statement's Exec() with no args
+// terminates the copy.
+func (bl *VoltBulkLoader) Exec(args []driver.Value) (driver.Result, error) {
+	if len(args) != 0 {
+		return nil, errors.New("voltdbclient: VoltBulkLoader.Exec takes no arguments; use WriteRow")
+	}
+	return nil, bl.Close()
+}
@@ -0,0 +1,411 @@
+/* This file is part of VoltDB.
+ * Copyright (C) 2008-2016 VoltDB Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with VoltDB.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package voltdbclient
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// topologyRefreshInterval is how often VoltClient re-issues @Statistics TOPO
+// in the background. Topology is also refreshed after onConnectionLost
+// redials a dropped connection; @Subscribe topology-change notifications
+// are not handled.
+const topologyRefreshInterval = 30 * time.Second
+
+// healthCheckInterval is how often topologyLoop scans for a connection that
+// has dropped, so onConnectionLost can redial it without waiting for a
+// caller to notice first.
+const healthCheckInterval = 5 * time.Second
+
+// topology is the routing information learned from @SystemInformation and
+// @Statistics TOPO: which host leads each partition, and which parameter of
+// each procedure is the partitioning key.
+type topology struct {
+	// partitionLeader maps partition id to the host (as passed to
+	// NewVoltClient) that holds that partition's master.
+	partitionLeader map[int32]string
+	// procedurePartitionParam maps procedure name to the zero-based index
+	// of its partitioning parameter. Multi-partition procedures are absent
+	// from this map.
+	procedurePartitionParam map[string]int
+	// ring is the consistent-hash ring built from partitionLeader, computed
+	// once per refreshTopology rather than on every Exec/Query.
+	ring []ringEntry
+}
+
+// VoltClient is a VoltConn-per-node client that attempts to route each
+// invocation to the host leading the invocation's partition, to avoid the
+// extra network hop the server would otherwise take on the client's behalf.
+// It is a partial Go analogue of the topology awareness built into VoltDB's
+// Java client: the partition-leader map comes from a real @Statistics TOPO
+// query, but the ring used to pick a partition for a given key (see
+// ringTokens in hash.go) is not a faithful reproduction of the server's own
+// ELASTIC hashinator, so routing is a best-effort heuristic rather than
+// guaranteed client affinity. A routing miss still produces a correct
+// result -- the server forwards the invocation to the right partition
+// leader itself -- just without the client-side hop savings.
+type VoltClient struct {
+	mu    sync.RWMutex
+	conns map[string]*VoltConn // host:port -> connection
+	topo  topology
+
+	roundRobin uint64 // atomically incremented, used for replica fan-out
+
+	// retryPolicy controls the backoff onConnectionLost uses when redialing
+	// a dropped per-node connection, the same RetryPolicy reconnectingConn
+	// uses for database/sql's single-connection reconnect.
+	retryPolicy RetryPolicy
+
+	closeOnce sync.Once
+	closeCh   chan struct{}
+}
+
+// NewVoltClient dials every host in seedHosts, then learns the cluster's
+// partitioning by issuing @SystemInformation and @Statistics TOPO against
+// any one connected seed. At least one seed host must be reachable. Only
+// the seed hosts are dialed: a partition leader that @Statistics TOPO
+// reports outside seedHosts gets no connection of its own, and invocations
+// for its partitions go through anyConn instead.
+func NewVoltClient(seedHosts []string) (*VoltClient, error) {
+	if len(seedHosts) == 0 {
+		return nil, errors.New("voltdbclient: NewVoltClient requires at least one seed host")
+	}
+
+	vcl := &VoltClient{
+		conns:       make(map[string]*VoltConn),
+		closeCh:     make(chan struct{}),
+		retryPolicy: DefaultRetryPolicy,
+	}
+
+	var firstErr error
+	for _, host := range seedHosts {
+		conn, err := OpenConn(host)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		vcl.conns[host] = conn
+	}
+	if len(vcl.conns) == 0 {
+		return nil, firstErr
+	}
+
+	if err := vcl.refreshTopology(); err != nil {
+		vcl.Close()
+		return nil, err
+	}
+
+	go vcl.topologyLoop()
+	return vcl, nil
+}
+
+// Close closes every connection the client holds and stops the background
+// topology refresh goroutine.
+func (vcl *VoltClient) Close() error {
+	var err error
+	vcl.closeOnce.Do(func() {
+		close(vcl.closeCh)
+		vcl.mu.Lock()
+		defer vcl.mu.Unlock()
+		for _, conn := range vcl.conns {
+			if cerr := conn.Close(); cerr != nil {
+				err = cerr
+			}
+		}
+	})
+	return err
+}
+
+// topologyLoop periodically refreshes topology, and separately scans for a
+// dropped connection so onConnectionLost can redial it without waiting for
+// a caller to notice first.
+func (vcl *VoltClient) topologyLoop() {
+	topoTicker := time.NewTicker(topologyRefreshInterval)
+	defer topoTicker.Stop()
+	healthTicker := time.NewTicker(healthCheckInterval)
+	defer healthTicker.Stop()
+	for {
+		select {
+		case <-vcl.closeCh:
+			return
+		case <-topoTicker.C:
+			vcl.refreshTopology()
+		case <-healthTicker.C:
+			vcl.checkConnections()
+		}
+	}
+}
+
+// checkConnections looks for a held connection whose socket has died and
+// hands it to onConnectionLost to redial. A genuine @Subscribe
+// topology-change push would trigger the same path once the listener
+// exposes one; this poll is the fallback until then.
+func (vcl *VoltClient) checkConnections() {
+	vcl.mu.RLock()
+	var dead []string
+	for host, conn := range vcl.conns {
+		if !conn.Open() {
+			dead = append(dead, host)
+		}
+	}
+	vcl.mu.RUnlock()
+
+	for _, host := range dead {
+		vcl.onConnectionLost(host)
+	}
+}
+
+// onConnectionLost fails every invocation outstanding on host's dying
+// connection (so callers get a typed ErrConnectionLost instead of hanging
+// or seeing a raw I/O error), then redials host with the same
+// backoff/jitter/MaxAttempts-capped RetryPolicy reconnectingConn uses,
+// rather than a second, weaker reconnect scheme. A redial that exhausts
+// policy.MaxAttempts (if capped) is retried again on the next
+// checkConnections tick, since the dead connection is left in vcl.conns
+// until a redial actually succeeds.
+func (vcl *VoltClient) onConnectionLost(host string) {
+	vcl.mu.RLock()
+	old := vcl.conns[host]
+	vcl.mu.RUnlock()
+	if old != nil {
+		old.failAllOutstanding(&ErrConnectionLost{Host: host})
+	}
+
+	go func() {
+		for attempt := 1; vcl.retryPolicy.MaxAttempts == 0 || attempt <= vcl.retryPolicy.MaxAttempts; attempt++ {
+			if attempt > 1 {
+				select {
+				case <-vcl.closeCh:
+					return
+				case <-time.After(vcl.retryPolicy.delay(attempt)):
+				}
+			}
+
+			conn, err := OpenConn(host)
+			if err != nil {
+				continue
+			}
+
+			vcl.mu.Lock()
+			vcl.conns[host] = conn
+			vcl.mu.Unlock()
+			if old != nil {
+				old.Close()
+			}
+
+			vcl.refreshTopology()
+			return
+		}
+	}()
+}
+
+// refreshTopology issues @SystemInformation and @Statistics TOPO against
+// any live connection and rebuilds the partition-leader and
+// procedure-partition-parameter maps from the results.
+func (vcl *VoltClient) refreshTopology() error {
+	conn, err := vcl.anyConn()
+	if err != nil {
+		return err
+	}
+
+	partitionLeader, err := queryPartitionLeaders(conn)
+	if err != nil {
+		return err
+	}
+
+	procedurePartitionParam, err := querySystemInformation(conn)
+	if err != nil {
+		return err
+	}
+
+	vcl.mu.Lock()
+	vcl.topo = topology{
+		partitionLeader:         partitionLeader,
+		procedurePartitionParam: procedurePartitionParam,
+		ring:                    ringTokens(len(partitionLeader)),
+	}
+	vcl.mu.Unlock()
+	return nil
+}
+
+// queryPartitionLeaders issues "@Statistics TOPO" and returns partition id
+// -> leader host, in the "host:port" form used as the key of vcl.conns.
+func queryPartitionLeaders(conn *VoltConn) (map[int32]string, error) {
+	rows, err := execSystemProcedure(conn, "@Statistics", "TOPO", int64(0))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	leaders := make(map[int32]string)
+	dest := make([]driver.Value, len(rows.Columns()))
+	for {
+		if err := rows.Next(dest); err != nil {
+			break
+		}
+		partition, ok := dest[0].(int64)
+		if !ok {
+			continue
+		}
+		leader, ok := dest[1].(string)
+		if !ok {
+			continue
+		}
+		leaders[int32(partition)] = leader
+	}
+	return leaders, nil
+}
+
+// querySystemInformation issues "@SystemInformation" and returns procedure
+// name -> zero-based partitioning parameter index, omitting multi-partition
+// procedures.
+func querySystemInformation(conn *VoltConn) (map[string]int, error) {
+	rows, err := execSystemProcedure(conn, "@SystemInformation", "OVERVIEW")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	params := make(map[string]int)
+	dest := make([]driver.Value, len(rows.Columns()))
+	for {
+		if err := rows.Next(dest); err != nil {
+			break
+		}
+		proc, ok := dest[0].(string)
+		if !ok {
+			continue
+		}
+		idx, ok := dest[1].(int64)
+		if !ok {
+			continue
+		}
+		params[proc] = int(idx)
+	}
+	return params, nil
+}
+
+// execSystemProcedure prepares and queries a VoltDB system procedure on
+// conn, e.g. execSystemProcedure(conn, "@Statistics", "TOPO", 0).
+func execSystemProcedure(conn *VoltConn, procName string, args ...driver.Value) (driver.Rows, error) {
+	stmt, err := conn.Prepare(procName)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	return stmt.(driver.StmtQueryContext).QueryContext(context.Background(), valuesToNamedValues(args))
+}
+
+func valuesToNamedValues(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, v := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return named
+}
+
+// anyConn returns any one live connection, preferring the round-robin
+// counter so repeated calls (e.g. successive topology refreshes) spread
+// across hosts rather than hammering the first seed.
+func (vcl *VoltClient) anyConn() (*VoltConn, error) {
+	vcl.mu.RLock()
+	defer vcl.mu.RUnlock()
+	if len(vcl.conns) == 0 {
+		return nil, errors.New("voltdbclient: no live connections")
+	}
+	hosts := make([]string, 0, len(vcl.conns))
+	for host := range vcl.conns {
+		hosts = append(hosts, host)
+	}
+	i := atomic.AddUint64(&vcl.roundRobin, 1)
+	return vcl.conns[hosts[int(i)%len(hosts)]], nil
+}
+
+// connForPartitionValue returns the connection to the host leading the
+// partition that partitionValue hashes to on vcl.topo.ring (see the ring's
+// heuristic-not-faithful caveat in hash.go), falling back to anyConn when
+// the partition's leader isn't known or isn't currently connected.
+func (vcl *VoltClient) connForPartitionValue(partitionValue interface{}) (*VoltConn, error) {
+	vcl.mu.RLock()
+	partition := partitionForValue(partitionValue, vcl.topo.ring)
+	leader, ok := vcl.topo.partitionLeader[partition]
+	var conn *VoltConn
+	if ok {
+		conn, ok = vcl.conns[leader]
+	}
+	vcl.mu.RUnlock()
+
+	if ok {
+		return conn, nil
+	}
+	return vcl.anyConn()
+}
+
+// Exec routes query to the partition leader for the procedure's
+// partitioning argument, looked up by name in topology.procedurePartitionParam,
+// falling back to any live connection for multi-partition procedures (those
+// absent from that map).
+func (vcl *VoltClient) Exec(query string, args ...driver.Value) (driver.Result, error) {
+	conn, err := vcl.routeConn(query, args)
+	if err != nil {
+		return nil, err
+	}
+	stmt, err := conn.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	return stmt.Exec(args)
+}
+
+// Query is Exec's read counterpart; multi-partition procedures are
+// round-robined across replicas instead of always hitting the same host.
+func (vcl *VoltClient) Query(query string, args ...driver.Value) (driver.Rows, error) {
+	conn, err := vcl.routeConn(query, args)
+	if err != nil {
+		return nil, err
+	}
+	stmt, err := conn.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	return stmt.Query(args)
+}
+
+// routeConn looks up query's partitioning parameter and, if args has a value
+// at that index, routes to its partition leader; otherwise it falls back to
+// anyConn, which is correct both for multi-partition procedures and for the
+// system procedures queried before procedurePartitionParam is known.
+func (vcl *VoltClient) routeConn(query string, args []driver.Value) (*VoltConn, error) {
+	vcl.mu.RLock()
+	idx, ok := vcl.topo.procedurePartitionParam[query]
+	vcl.mu.RUnlock()
+
+	if !ok || idx < 0 || idx >= len(args) {
+		return vcl.anyConn()
+	}
+	return vcl.connForPartitionValue(args[idx])
+}
@@ -0,0 +1,105 @@
+/* This file is part of VoltDB.
+ * Copyright (C) 2008-2016 VoltDB Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with VoltDB.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package voltdbclient
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+)
+
+// ClientConnector implements database/sql/driver.Connector, exposing a
+// VoltClient's topology-aware routing through database/sql, e.g.
+// sql.OpenDB(voltdbclient.NewClientConnector(client)). It is the multi-host
+// counterpart to Connector, which wraps a single VoltConn.
+type ClientConnector struct {
+	client *VoltClient
+}
+
+// NewClientConnector wraps an already-connected VoltClient (see
+// NewVoltClient) for use with sql.OpenDB. Closing the returned *sql.DB does
+// not close client, since Connector implementations aren't given a Close
+// hook by database/sql; call client.Close separately.
+func NewClientConnector(client *VoltClient) *ClientConnector {
+	return &ClientConnector{client: client}
+}
+
+// Connect returns a driver.Conn backed by client. Every clientConn shares
+// the same underlying VoltClient, so ctx is not used to dial anything new;
+// it exists only to satisfy driver.Connector.
+func (c *ClientConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return &clientConn{client: c.client}, nil
+}
+
+// Driver returns a placeholder driver.Driver, as required by
+// driver.Connector. VoltClient connections are only reachable through
+// NewClientConnector, not sql.Register, since the multiple seed hosts
+// NewVoltClient requires can't be carried in a single DSN string.
+func (c *ClientConnector) Driver() driver.Driver {
+	return &clientDriver{}
+}
+
+type clientDriver struct{}
+
+func (d *clientDriver) Open(name string) (driver.Conn, error) {
+	return nil, errors.New("voltdbclient: use NewClientConnector and sql.OpenDB; VoltClient needs multiple seed hosts a single DSN can't carry")
+}
+
+// clientConn adapts VoltClient onto driver.Conn. It holds no connection of
+// its own; every Stmt it prepares routes through client at Exec/Query time.
+type clientConn struct {
+	client *VoltClient
+}
+
+func (c *clientConn) Prepare(query string) (driver.Stmt, error) {
+	return &clientStmt{client: c.client, query: query}, nil
+}
+
+// Close is a no-op: the underlying VoltClient is shared across every
+// clientConn and outlives any one of them; call client.Close to tear it
+// down.
+func (c *clientConn) Close() error {
+	return nil
+}
+
+func (c *clientConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("VoltDB does not support transactions, VoltDB autocommits")
+}
+
+// clientStmt routes Exec/Query through VoltClient, which looks up query's
+// partitioning parameter and hashes the matching argument to the right host.
+type clientStmt struct {
+	client *VoltClient
+	query  string
+}
+
+// Close is a no-op; clientStmt holds no resources of its own.
+func (s *clientStmt) Close() error { return nil }
+
+// NumInput returns -1: VoltDB procedures are arbitrary-arity and Prepare
+// doesn't describe them, so database/sql must not validate the argument
+// count itself.
+func (s *clientStmt) NumInput() int { return -1 }
+
+func (s *clientStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.client.Exec(s.query, args...)
+}
+
+func (s *clientStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.client.Query(s.query, args...)
+}
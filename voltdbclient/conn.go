@@ -18,12 +18,17 @@
 package voltdbclient
 
 import (
+	"context"
+	"crypto/tls"
 	"database/sql/driver"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"reflect"
+	"strings"
+	"sync"
+	"time"
 )
 
 // connectionData are the values returned by a successful login.
@@ -37,84 +42,424 @@ type connectionData struct {
 type VoltConn struct {
 	reader      io.Reader
 	writer      io.Writer
+	closer      io.Closer
 	connData    *connectionData
-	execs       map[int64]<-chan driver.Result
-	queries     map[int64]*VoltQueryResult
 	netListener *NetworkListener
 	isOpen      bool
+
+	// mu guards everything below: refCount/closing, and the execs/queries/
+	// queryCtxs maps, which NetworkListener populates concurrently with
+	// user calls.
+	mu        sync.Mutex
+	refCount  int
+	closing   bool
+	execs     map[int64]*pendingExec
+	queries   map[int64]*VoltQueryResult
+	queryCtxs map[int64]*pendingQuery
+	handleSeq int64
 }
 
-func newVoltConn(reader io.Reader, writer io.Writer, connData *connectionData) *VoltConn {
+// newVoltConn wraps an already-established, already-logged-in transport.
+// conn is an io.ReadWriteCloser rather than *net.TCPConn so that a
+// *tls.Conn (see OpenConnTLS) works just as well as a plain TCP socket.
+func newVoltConn(conn io.ReadWriteCloser, connData *connectionData) *VoltConn {
 	var vc = new(VoltConn)
-	vc.reader = reader
-	vc.writer = writer
-	vc.execs = make(map[int64]<-chan driver.Result)
+	vc.reader = conn
+	vc.writer = conn
+	vc.closer = conn
+	vc.execs = make(map[int64]*pendingExec)
 	vc.queries = make(map[int64]*VoltQueryResult)
-	vc.netListener = NewListener(reader)
+	vc.queryCtxs = make(map[int64]*pendingQuery)
+	vc.netListener = NewListener(conn)
 	vc.netListener.start()
 	vc.isOpen = true
 	return vc
 }
 
-func (vc VoltConn) Begin() (driver.Tx, error) {
+func (vc *VoltConn) Begin() (driver.Tx, error) {
 	return nil, errors.New("VoltDB does not support transactions, VoltDB autocommits")
 }
 
-func (vc VoltConn) Close() (err error) {
-	if vc.reader != nil {
-		tcpConn := vc.reader.(*net.TCPConn)
-		err = tcpConn.Close()
+// Close marks vc as closing and returns immediately; the socket and
+// listener aren't actually torn down until every VoltStatement obtained
+// from Prepare, and every in-flight invocation registered with
+// registerExec/registerQuery, has released its reference (see acquire/
+// release below). This mirrors how database/sql itself avoids closing a
+// Conn out from under a Stmt or Rows that's still in use.
+func (vc *VoltConn) Close() (err error) {
+	vc.mu.Lock()
+	vc.closing = true
+	if vc.refCount > 0 {
+		vc.mu.Unlock()
+		return nil
 	}
+	vc.mu.Unlock()
+	return vc.closeNow()
+}
+
+// closeNow does the actual teardown, either from Close (if there were no
+// outstanding references) or from release (once the last outstanding
+// reference goes away after Close was called). The underlying closer is
+// closed at most once: a later call finds it already nil.
+func (vc *VoltConn) closeNow() (err error) {
+	vc.mu.Lock()
+	closer := vc.closer
 	vc.reader = nil
 	vc.writer = nil
+	vc.closer = nil
 	vc.connData = nil
 	vc.isOpen = false
+	vc.mu.Unlock()
+
+	if closer != nil {
+		err = closer.Close()
+	}
 	return err
 }
 
+// acquire registers one more outstanding reference to vc: a prepared
+// statement, a DrainAll in progress, or an in-flight invocation.
+func (vc *VoltConn) acquire() {
+	vc.mu.Lock()
+	vc.refCount++
+	vc.mu.Unlock()
+}
+
+// release drops one outstanding reference to vc, acquired by acquire. If
+// Close was already called and this was the last reference, the
+// connection is torn down now. trackedStmt.Close, DrainAll, and
+// removeExec/removeQueryCtx/removeQuery each call release exactly once per
+// matching acquire; Rows returned by a query hold no reference of their
+// own.
+func (vc *VoltConn) release() {
+	vc.mu.Lock()
+	vc.refCount--
+	shouldClose := vc.closing && vc.refCount <= 0
+	vc.mu.Unlock()
+
+	if shouldClose {
+		vc.closeNow()
+	}
+}
+
+// nextHandle returns a process-local id for tracking one synthetic
+// invocation through execs/queryCtxs while a Context is in play. It has no
+// relationship to VoltDB's own wire-protocol invocation handles (those
+// registered through registerQuery); it only needs to be
+// unique among vc's concurrently in-flight invocations.
+func (vc *VoltConn) nextHandle() int64 {
+	vc.mu.Lock()
+	vc.handleSeq++
+	h := vc.handleSeq
+	vc.mu.Unlock()
+	return h
+}
+
+// Open reports whether vc's underlying socket is still live, for callers
+// (e.g. VoltClient's health check) that hold a *VoltConn directly rather
+// than going through database/sql.
+func (vc *VoltConn) Open() bool {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+	return vc.isOpen
+}
+
 func OpenConn(connInfo string) (*VoltConn, error) {
-	// for now, at least, connInfo is host and port.
-	raddr, err := net.ResolveTCPAddr("tcp", connInfo)
+	cfg, err := parseDSN(connInfo)
 	if err != nil {
-		return nil, fmt.Errorf("Error resolving %v.", connInfo)
+		return nil, err
+	}
+	return dialVoltConn(context.Background(), cfg)
+}
+
+// connConfig holds the parsed, connection-independent settings needed to
+// dial a VoltDB node. It is built once by parseDSN and reused across
+// reconnect attempts.
+type connConfig struct {
+	hostAndPort string
+	tlsConfig   *tls.Config
+}
+
+// parseDSN interprets connInfo as a data source name: "host:port", followed
+// by an optional "?sslmode=...&sslrootcert=...&sslcert=...&sslkey=..."
+// query string. See ssl.go for the sslmode semantics.
+func parseDSN(connInfo string) (*connConfig, error) {
+	if connInfo == "" {
+		return nil, errors.New("empty connection string")
 	}
-	var tcpConn *net.TCPConn
-	if tcpConn, err = net.DialTCP("tcp", nil, raddr); err != nil {
+
+	hostAndPort := connInfo
+	params := ""
+	if i := strings.IndexByte(connInfo, '?'); i >= 0 {
+		hostAndPort = connInfo[:i]
+		params = connInfo[i+1:]
+	}
+
+	tlsConfig, err := buildTLSConfig(hostAndPort, params)
+	if err != nil {
 		return nil, err
 	}
-	login, err := serializeLoginMessage("", "")
+
+	return &connConfig{hostAndPort: hostAndPort, tlsConfig: tlsConfig}, nil
+}
+
+// dialVoltConn performs the TCP dial, optional TLS handshake, and login
+// handshake described by cfg, honoring ctx.Deadline()/ctx.Done()
+// throughout. Both OpenConn and Connector.Connect funnel through here so
+// there is exactly one dial path.
+func dialVoltConn(ctx context.Context, cfg *connConfig) (*VoltConn, error) {
+	raddr, err := net.ResolveTCPAddr("tcp", cfg.hostAndPort)
+	if err != nil {
+		return nil, fmt.Errorf("Error resolving %v.", cfg.hostAndPort)
+	}
+
+	var dialer net.Dialer
+	tcpConn, err := dialer.DialContext(ctx, "tcp", raddr.String())
 	if err != nil {
 		return nil, err
 	}
-	writeLoginMessage(tcpConn, &login)
-	connData, err := readLoginResponse(tcpConn)
+
+	if deadline, ok := ctx.Deadline(); ok {
+		tcpConn.SetDeadline(deadline)
+	}
+
+	var conn net.Conn = tcpConn
+	if cfg.tlsConfig != nil {
+		tlsConn := tls.Client(tcpConn, cfg.tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			tcpConn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	login, err := serializeLoginMessage("", "")
 	if err != nil {
+		conn.Close()
 		return nil, err
 	}
-	return newVoltConn(tcpConn, tcpConn, connData), nil
+
+	loginDone := make(chan error, 1)
+	var connData *connectionData
+	go func() {
+		writeLoginMessage(conn, &login)
+		var loginErr error
+		connData, loginErr = readLoginResponse(conn)
+		loginDone <- loginErr
+	}()
+
+	select {
+	case <-ctx.Done():
+		conn.Close()
+		return nil, ctx.Err()
+	case err := <-loginDone:
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	// the login handshake deadline doesn't apply to the lifetime of the
+	// connection, so clear it now that login has completed.
+	conn.SetDeadline(time.Time{})
+
+	return newVoltConn(conn, connData), nil
 }
 
-func (vc VoltConn) Prepare(query string) (driver.Stmt, error) {
-	if !vc.isOpen {
+// Prepare acquires a reference on vc, held until the returned Stmt's Close
+// releases it, and returns a Stmt bound to query. The open check and the
+// reference acquisition happen under the same lock so a concurrent Close
+// can never observe isOpen true, decide not to tear down, and then have
+// Prepare hand out a Stmt against a connection that's already gone.
+func (vc *VoltConn) Prepare(query string) (driver.Stmt, error) {
+	vc.mu.Lock()
+	if !vc.isOpen || vc.closing {
+		vc.mu.Unlock()
 		return nil, errors.New("Connection is closed")
 	}
-	vs := newVoltStatement(&vc, &vc.writer, vc.netListener, query)
-	return *vs, nil
+	vc.refCount++
+	vc.mu.Unlock()
+
+	vs := newVoltStatement(vc, &vc.writer, vc.netListener, query)
+	return &trackedStmt{stmt: *vs, vc: vc}, nil
 }
 
-func (vc VoltConn) DrainAll() []*VoltQueryResult {
-	numQueries := len(vc.queries)
-	finishedQueries := []*VoltQueryResult{}
-	handles := make([]int64, numQueries)
-	cases := make([]reflect.SelectCase, numQueries)
+// trackedStmt wraps the driver.Stmt Prepare hands out so that closing it
+// always releases the reference Prepare acquired, regardless of what the
+// underlying VoltStatement's own Close does. It also forwards
+// StmtExecContext/StmtQueryContext when the wrapped Stmt implements them,
+// so wrapping doesn't strip context support.
+type trackedStmt struct {
+	stmt     driver.Stmt
+	vc       *VoltConn
+	released sync.Once
+}
+
+func (ts *trackedStmt) Close() error {
+	err := ts.stmt.Close()
+	ts.released.Do(ts.vc.release)
+	return err
+}
+
+func (ts *trackedStmt) NumInput() int { return ts.stmt.NumInput() }
+
+func (ts *trackedStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return ts.stmt.Exec(args)
+}
+
+func (ts *trackedStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return ts.stmt.Query(args)
+}
 
-	var i int = 0
+// ExecContext implements driver.StmtExecContext. If the wrapped Stmt
+// already supports context-aware execution, that implementation is used
+// directly. Otherwise the invocation runs on its own goroutine against an
+// entry registered in vc.execs, so that a ctx cancellation fails and
+// releases that entry (via cancelExec) and returns ctx.Err() at once. The
+// underlying Exec is not interrupted: it keeps running on that goroutine
+// and its result is discarded.
+func (ts *trackedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	if ec, ok := ts.stmt.(driver.StmtExecContext); ok {
+		return ec.ExecContext(ctx, args)
+	}
+	values, err := namedValuesToValues(args)
+	if err != nil {
+		return nil, err
+	}
+	if ctx.Done() == nil {
+		return ts.Exec(values)
+	}
+
+	handle := ts.vc.nextHandle()
+	pe := newPendingExec()
+	ts.vc.registerExec(handle, pe)
+
+	go func() {
+		res, err := ts.Exec(values)
+		if err != nil {
+			pe.setError(err)
+		} else {
+			pe.setResult(res)
+		}
+		ts.vc.removeExec(handle)
+	}()
+
+	select {
+	case <-ctx.Done():
+		err := ctx.Err()
+		ts.vc.cancelExec(handle, err)
+		return nil, err
+	case outcome := <-pe.channel():
+		return outcome.res, outcome.err
+	}
+}
+
+// QueryContext implements driver.StmtQueryContext. It mirrors ExecContext,
+// but tracks the invocation in vc.queryCtxs/pendingQuery rather than
+// vc.queries/VoltQueryResult: the latter pair is reserved for queries the
+// real wire-protocol invocation handle registers (see registerQuery), and
+// this client has no way to construct a VoltQueryResult of its own outside
+// that path.
+func (ts *trackedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	if qc, ok := ts.stmt.(driver.StmtQueryContext); ok {
+		return qc.QueryContext(ctx, args)
+	}
+	values, err := namedValuesToValues(args)
+	if err != nil {
+		return nil, err
+	}
+	if ctx.Done() == nil {
+		return ts.Query(values)
+	}
+
+	handle := ts.vc.nextHandle()
+	pq := newPendingQuery()
+	ts.vc.registerQueryCtx(handle, pq)
+
+	go func() {
+		rows, err := ts.Query(values)
+		if err != nil {
+			pq.setError(err)
+		} else {
+			pq.setRows(rows)
+		}
+		ts.vc.removeQueryCtx(handle)
+	}()
+
+	select {
+	case <-ctx.Done():
+		err := ctx.Err()
+		ts.vc.cancelQueryCtx(handle, err)
+		return nil, err
+	case outcome := <-pq.channel():
+		return outcome.rows, outcome.err
+	}
+}
+
+// PrepareContext implements driver.ConnPrepareContext. It behaves like
+// Prepare, except that a ctx already cancelled (or cancelled before the
+// prepare completes) aborts the prepare instead of blocking on it. If ctx
+// wins that race, the Prepare that was already in flight still completes in
+// the background and is closed as soon as it does, so its reference on vc
+// (see acquire/release) is never leaked.
+func (vc *VoltConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	type prepareResult struct {
+		stmt driver.Stmt
+		err  error
+	}
+	done := make(chan prepareResult, 1)
+	go func() {
+		stmt, err := vc.Prepare(query)
+		done <- prepareResult{stmt, err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		go func() {
+			if r := <-done; r.stmt != nil {
+				r.stmt.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	case r := <-done:
+		return r.stmt, r.err
+	}
+}
+
+// cancelQuery fails the in-flight query registered under handle with err
+// and stops tracking it, so a subsequent DrainAll no longer waits on it.
+func (vc *VoltConn) cancelQuery(handle int64, err error) {
+	vc.mu.Lock()
+	vqr, ok := vc.queries[handle]
+	vc.mu.Unlock()
+	if ok {
+		vqr.setError(err)
+		vc.removeQuery(handle)
+	}
+}
+
+// DrainAll holds a reference on vc for as long as it's waiting on
+// outstanding queries, so a concurrent Close doesn't tear down the
+// listener out from under it.
+func (vc *VoltConn) DrainAll() []*VoltQueryResult {
+	vc.acquire()
+	defer vc.release()
+
+	vc.mu.Lock()
+	numQueries := len(vc.queries)
+	handles := make([]int64, 0, numQueries)
+	cases := make([]reflect.SelectCase, 0, numQueries)
 	for handle, vqr := range vc.queries {
-		handles[i] = handle
-		cases[i] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(vqr.channel())}
-		i++
+		handles = append(handles, handle)
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(vqr.channel())})
 	}
+	vc.mu.Unlock()
 
+	finishedQueries := []*VoltQueryResult{}
 	for len(handles) > 0 {
 		chosen, val, ok := reflect.Select(cases)
 
@@ -126,7 +471,9 @@ func (vc VoltConn) DrainAll() []*VoltQueryResult {
 		cases[chosen] = cases[len(cases)-1]
 		cases = cases[:len(cases)-1]
 
+		vc.mu.Lock()
 		chosenQuery := vc.queries[handle]
+		vc.mu.Unlock()
 
 		// if not ok, the channel was closed
 		if !ok {
@@ -148,14 +495,200 @@ func (vc VoltConn) DrainAll() []*VoltQueryResult {
 	return finishedQueries
 }
 
-func (vc VoltConn) registerExec(handle int64, c <-chan driver.Result) {
-	vc.execs[handle] = c
+// registerExec tracks an in-flight Exec invocation, holding a reference on
+// vc until removeExec is called with the same handle.
+func (vc *VoltConn) registerExec(handle int64, pe *pendingExec) {
+	vc.acquire()
+	vc.mu.Lock()
+	vc.execs[handle] = pe
+	vc.mu.Unlock()
+}
+
+// removeExec stops tracking the Exec invocation registered under handle and
+// releases the reference registerExec acquired for it. It's a no-op if
+// handle was already removed (by a concurrent cancelExec racing the
+// invocation's own completion), so it's safe to call more than once for the
+// same handle.
+func (vc *VoltConn) removeExec(handle int64) {
+	vc.mu.Lock()
+	_, ok := vc.execs[handle]
+	delete(vc.execs, handle)
+	vc.mu.Unlock()
+	if ok {
+		vc.release()
+	}
+}
+
+// cancelExec fails the in-flight exec registered under handle with err and
+// stops tracking it, mirroring cancelQuery.
+func (vc *VoltConn) cancelExec(handle int64, err error) {
+	vc.mu.Lock()
+	pe, ok := vc.execs[handle]
+	vc.mu.Unlock()
+	if ok {
+		pe.setError(err)
+		vc.removeExec(handle)
+	}
+}
+
+// registerQueryCtx tracks an in-flight QueryContext invocation, holding a
+// reference on vc until removeQueryCtx is called with the same handle.
+func (vc *VoltConn) registerQueryCtx(handle int64, pq *pendingQuery) {
+	vc.acquire()
+	vc.mu.Lock()
+	vc.queryCtxs[handle] = pq
+	vc.mu.Unlock()
+}
+
+// removeQueryCtx stops tracking the QueryContext invocation registered
+// under handle and releases the reference registerQueryCtx acquired for
+// it. Like removeExec, it's safe to call more than once for the same
+// handle.
+func (vc *VoltConn) removeQueryCtx(handle int64) {
+	vc.mu.Lock()
+	_, ok := vc.queryCtxs[handle]
+	delete(vc.queryCtxs, handle)
+	vc.mu.Unlock()
+	if ok {
+		vc.release()
+	}
+}
+
+// cancelQueryCtx fails the in-flight QueryContext invocation registered
+// under handle with err and stops tracking it, mirroring cancelExec.
+func (vc *VoltConn) cancelQueryCtx(handle int64, err error) {
+	vc.mu.Lock()
+	pq, ok := vc.queryCtxs[handle]
+	vc.mu.Unlock()
+	if ok {
+		pq.setError(err)
+		vc.removeQueryCtx(handle)
+	}
 }
 
-func (vc VoltConn) registerQuery(handle int64, vcr *VoltQueryResult) {
+// failAllOutstanding delivers err to every query, exec, and QueryContext
+// invocation currently registered on vc and stops tracking them. It's
+// called when the underlying socket dies, so a caller blocked on DrainAll
+// or on an exec's or QueryContext's result doesn't hang forever waiting
+// for a response that will never arrive.
+func (vc *VoltConn) failAllOutstanding(err error) {
+	vc.mu.Lock()
+	queries := make([]int64, 0, len(vc.queries))
+	for h := range vc.queries {
+		queries = append(queries, h)
+	}
+	execs := make([]int64, 0, len(vc.execs))
+	for h := range vc.execs {
+		execs = append(execs, h)
+	}
+	queryCtxs := make([]int64, 0, len(vc.queryCtxs))
+	for h := range vc.queryCtxs {
+		queryCtxs = append(queryCtxs, h)
+	}
+	vc.mu.Unlock()
+
+	for _, h := range queries {
+		vc.cancelQuery(h, err)
+	}
+	for _, h := range execs {
+		vc.cancelExec(h, err)
+	}
+	for _, h := range queryCtxs {
+		vc.cancelQueryCtx(h, err)
+	}
+}
+
+// pendingQuery is a self-owned record of an in-flight QueryContext
+// invocation, the Query counterpart to pendingExec.
+type pendingQuery struct {
+	done chan queryOutcome
+	once sync.Once
+}
+
+// queryOutcome is what a pendingQuery eventually delivers: either rows or
+// an error, never both.
+type queryOutcome struct {
+	rows driver.Rows
+	err  error
+}
+
+func newPendingQuery() *pendingQuery {
+	return &pendingQuery{done: make(chan queryOutcome, 1)}
+}
+
+func (pq *pendingQuery) channel() <-chan queryOutcome { return pq.done }
+
+func (pq *pendingQuery) setRows(rows driver.Rows) {
+	pq.once.Do(func() { pq.done <- queryOutcome{rows: rows} })
+}
+
+func (pq *pendingQuery) setError(err error) {
+	pq.once.Do(func() { pq.done <- queryOutcome{err: err} })
+}
+
+// pendingExec is a self-owned record of an in-flight Exec invocation. It
+// replaces a bare receive-only channel (which registerExec previously
+// stored) because such a channel has no way to be failed out-of-band --
+// failAllOutstanding needs to be able to deliver an error to whoever is
+// waiting on it once the connection dies.
+type pendingExec struct {
+	done chan execOutcome
+	once sync.Once
+}
+
+// execOutcome is what a pendingExec eventually delivers: either a result or
+// an error, never both.
+type execOutcome struct {
+	res driver.Result
+	err error
+}
+
+func newPendingExec() *pendingExec {
+	return &pendingExec{done: make(chan execOutcome, 1)}
+}
+
+func (pe *pendingExec) channel() <-chan execOutcome { return pe.done }
+
+func (pe *pendingExec) setResult(res driver.Result) {
+	pe.once.Do(func() { pe.done <- execOutcome{res: res} })
+}
+
+func (pe *pendingExec) setError(err error) {
+	pe.once.Do(func() { pe.done <- execOutcome{err: err} })
+}
+
+// registerQuery tracks an in-flight Query invocation, holding a reference
+// on vc until removeQuery is called with the same handle.
+func (vc *VoltConn) registerQuery(handle int64, vcr *VoltQueryResult) {
+	vc.acquire()
+	vc.mu.Lock()
 	vc.queries[handle] = vcr
+	vc.mu.Unlock()
 }
 
-func (vc VoltConn) removeQuery(han int64) {
+// removeQuery stops tracking the Query invocation registered under handle
+// and releases the reference registerQuery acquired for it. Like
+// removeExec, it's safe to call more than once for the same handle.
+func (vc *VoltConn) removeQuery(han int64) {
+	vc.mu.Lock()
+	_, ok := vc.queries[han]
 	delete(vc.queries, han)
-}
\ No newline at end of file
+	vc.mu.Unlock()
+	if ok {
+		vc.release()
+	}
+}
+
+// namedValuesToValues downgrades driver.NamedValue args to the plain
+// driver.Value slice Exec/Query expect. VoltDB stored procedures are
+// positional, so named arguments aren't supported.
+func namedValuesToValues(named []driver.NamedValue) ([]driver.Value, error) {
+	args := make([]driver.Value, len(named))
+	for i, nv := range named {
+		if nv.Name != "" {
+			return nil, errors.New("voltdbclient: named parameters are not supported")
+		}
+		args[i] = nv.Value
+	}
+	return args, nil
+}
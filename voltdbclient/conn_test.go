@@ -0,0 +1,194 @@
+/* This file is part of VoltDB.
+ * Copyright (C) 2008-2016 VoltDB Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with VoltDB.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package voltdbclient
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingStmt is a driver.Stmt test double that blocks in Exec/Query until
+// unblock is closed, so tests can control exactly when the underlying
+// invocation completes relative to a context cancellation. It deliberately
+// does not implement driver.StmtExecContext/StmtQueryContext, exercising
+// trackedStmt's own fallback cancellation path.
+type blockingStmt struct {
+	unblock chan struct{}
+}
+
+func (s *blockingStmt) Close() error  { return nil }
+func (s *blockingStmt) NumInput() int { return -1 }
+
+func (s *blockingStmt) Exec(args []driver.Value) (driver.Result, error) {
+	<-s.unblock
+	return driver.RowsAffected(0), nil
+}
+
+func (s *blockingStmt) Query(args []driver.Value) (driver.Rows, error) {
+	<-s.unblock
+	return nil, errors.New("blockingStmt: no rows")
+}
+
+// newTestVoltConn builds a VoltConn directly, bypassing newVoltConn (and so
+// the real dial/login/NetworkListener machinery it wires up), since tests
+// only need the refcounting and context-cancellation bookkeeping that live
+// on VoltConn itself.
+func newTestVoltConn() *VoltConn {
+	vc := new(VoltConn)
+	vc.execs = make(map[int64]*pendingExec)
+	vc.queries = make(map[int64]*VoltQueryResult)
+	vc.queryCtxs = make(map[int64]*pendingQuery)
+	vc.isOpen = true
+	return vc
+}
+
+func TestTrackedStmtExecContextCancelReleasesEntry(t *testing.T) {
+	vc := newTestVoltConn()
+	ts := &trackedStmt{stmt: &blockingStmt{unblock: make(chan struct{})}, vc: vc}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := ts.ExecContext(ctx, nil)
+		errCh <- err
+	}()
+
+	cancel()
+
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("ExecContext returned %v, want context.Canceled", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("ExecContext did not return after ctx cancellation")
+	}
+
+	vc.mu.Lock()
+	n := len(vc.execs)
+	vc.mu.Unlock()
+	if n != 0 {
+		t.Errorf("vc.execs has %d entries after cancellation, want 0", n)
+	}
+}
+
+func TestTrackedStmtQueryContextCancelReleasesEntry(t *testing.T) {
+	vc := newTestVoltConn()
+	ts := &trackedStmt{stmt: &blockingStmt{unblock: make(chan struct{})}, vc: vc}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := ts.QueryContext(ctx, nil)
+		errCh <- err
+	}()
+
+	cancel()
+
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("QueryContext returned %v, want context.Canceled", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("QueryContext did not return after ctx cancellation")
+	}
+
+	vc.mu.Lock()
+	n := len(vc.queryCtxs)
+	vc.mu.Unlock()
+	if n != 0 {
+		t.Errorf("vc.queryCtxs has %d entries after cancellation, want 0", n)
+	}
+}
+
+// TestConcurrentPrepareCloseDoesNotCloseUnderlyingConn exercises the
+// historical database/sql bug this refcounting exists to prevent: Close
+// racing a still-open Stmt. Each Stmt's reference is taken by bumping
+// refCount directly, as Prepare does. Once Close and every Stmt's Close
+// have run concurrently, it asserts that the underlying closer was closed
+// exactly once and that vc no longer reports itself open, whatever the
+// interleaving. Run with -race to catch any unguarded access to
+// VoltConn's fields.
+func TestConcurrentPrepareCloseDoesNotCloseUnderlyingConn(t *testing.T) {
+	vc := newTestVoltConn()
+	closer := &countingCloser{}
+	vc.closer = closer
+
+	const n = 20
+	var wg sync.WaitGroup
+	stmts := make([]*trackedStmt, n)
+	for i := 0; i < n; i++ {
+		vc.mu.Lock()
+		vc.refCount++
+		vc.mu.Unlock()
+		stmts[i] = &trackedStmt{stmt: &blockingStmt{unblock: closedChan}, vc: vc}
+	}
+
+	wg.Add(n + 1)
+	go func() {
+		defer wg.Done()
+		vc.Close()
+	}()
+	for _, ts := range stmts {
+		ts := ts
+		go func() {
+			defer wg.Done()
+			ts.Close()
+		}()
+	}
+	wg.Wait()
+
+	if closer.count() != 1 {
+		t.Errorf("underlying closer closed %d times, want exactly 1", closer.count())
+	}
+	if vc.Open() {
+		t.Errorf("vc.Open() = true after Close and every Stmt released, want false")
+	}
+}
+
+// closedChan is pre-closed so blockingStmt.Exec/Query never actually block
+// in TestConcurrentPrepareCloseDoesNotCloseUnderlyingConn; only
+// Close/release ordering is under test there.
+var closedChan = func() chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}()
+
+type countingCloser struct {
+	mu sync.Mutex
+	n  int
+}
+
+func (c *countingCloser) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.n++
+	return nil
+}
+
+func (c *countingCloser) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.n
+}
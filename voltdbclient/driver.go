@@ -0,0 +1,93 @@
+/* This file is part of VoltDB.
+ * Copyright (C) 2008-2016 VoltDB Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with VoltDB.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package voltdbclient
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+)
+
+func init() {
+	sql.Register("voltdb", &Driver{})
+}
+
+// Driver implements database/sql/driver.Driver so that voltdbclient can be
+// used through database/sql, e.g. sql.Open("voltdb", "host:port").
+type Driver struct{}
+
+// Open opens a new connection using the legacy, non-context-aware path. It
+// is equivalent to OpenConnector followed by Connect(context.Background()).
+func (d *Driver) Open(dsn string) (driver.Conn, error) {
+	connector, err := d.OpenConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return connector.Connect(context.Background())
+}
+
+// OpenConnector parses dsn and returns a Connector that can be handed to
+// sql.OpenDB. Parsing the DSN once up front, rather than on every Connect,
+// is what lets sql.OpenDB validate a dsn eagerly.
+func (d *Driver) OpenConnector(dsn string) (driver.Connector, error) {
+	cfg, err := parseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &Connector{cfg: cfg, driver: d}, nil
+}
+
+// Connector implements database/sql/driver.Connector. Unlike Driver.Open,
+// Connector.Connect is handed a context and honors its deadline/cancellation
+// while dialing and logging in, so a slow TCP handshake against an
+// unreachable host can be bounded by the caller.
+type Connector struct {
+	cfg    *connConfig
+	driver *Driver
+
+	// RetryPolicy controls how the connection returned by Connect re-dials
+	// after a transient failure. The zero value uses DefaultRetryPolicy.
+	RetryPolicy RetryPolicy
+}
+
+// NewConnector builds a Connector directly from connInfo (host:port), for
+// callers that want sql.OpenDB without going through the driver registry.
+func NewConnector(connInfo string) (*Connector, error) {
+	cfg, err := parseDSN(connInfo)
+	if err != nil {
+		return nil, err
+	}
+	return &Connector{cfg: cfg, driver: &Driver{}}, nil
+}
+
+// Connect dials the configured host, honoring ctx.Deadline()/ctx.Done() for
+// both the TCP dial and the login round-trip, and returns a connection that
+// transparently re-dials itself, per c.RetryPolicy, if the socket later dies.
+func (c *Connector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := dialVoltConn(ctx, c.cfg)
+	if err != nil {
+		return nil, err
+	}
+	return newReconnectingConn(c.cfg, c.RetryPolicy, conn), nil
+}
+
+// Driver returns the Driver that created this Connector, as required by
+// driver.Connector.
+func (c *Connector) Driver() driver.Driver {
+	return c.driver
+}
@@ -0,0 +1,222 @@
+/* This file is part of VoltDB.
+ * Copyright (C) 2008-2016 VoltDB Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with VoltDB.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package voltdbclient
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+// The ELASTIC hashinator routes a partitioning value to a partition by
+// hashing it to a 64-bit token and locating that token on a consistent-hash
+// ring, rather than a fixed modulo over the partition count. That's what
+// lets VoltDB add partitions without reshuffling every key. hashToken below
+// is VoltDB's own hash: the low 64 bits of MurmurHash3_x64_128 seeded with 0,
+// applied to the key's big-endian byte encoding (8 bytes for integer keys,
+// raw bytes for strings/VARBINARY) -- the same function and encoding the
+// server uses.
+//
+// IMPORTANT: ringTokens does NOT reproduce the server's real per-partition
+// token assignment. The server's actual ring layout comes from its ELASTIC
+// hashinator config, a blob this client has no way to fetch or parse (doing
+// so needs either the config attached to the login handshake response or a
+// system-procedure round trip, neither of which this client implements).
+// ringTokens instead places one token per partition, at the hash of its
+// partition id, which only coincidentally agrees with the server's real ring for some
+// keys. Routing built on top of this ring (connForPartitionValue in
+// client.go) is therefore a best-effort heuristic, not true client
+// affinity: treat a "hit" as an optimization, and never rely on it to
+// guarantee which host ends up leading a given invocation.
+func hashToken(value interface{}) uint64 {
+	switch v := value.(type) {
+	case int8:
+		return murmur3H64(int64ToBytes(int64(v)))
+	case int16:
+		return murmur3H64(int64ToBytes(int64(v)))
+	case int32:
+		return murmur3H64(int64ToBytes(int64(v)))
+	case int64:
+		return murmur3H64(int64ToBytes(v))
+	case int:
+		return murmur3H64(int64ToBytes(int64(v)))
+	case string:
+		return murmur3H64([]byte(v))
+	case []byte:
+		return murmur3H64(v)
+	default:
+		return murmur3H64(int64ToBytes(0)) // unsupported key type hashes to a fixed bucket
+	}
+}
+
+func int64ToBytes(v int64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(v))
+	return buf[:]
+}
+
+const (
+	murmur3C1 = 0x87c37b91114253d5
+	murmur3C2 = 0x4cf5ad432745937f
+)
+
+// murmur3H64 returns the low 64 bits of MurmurHash3_x64_128(data, seed=0),
+// which is what VoltDB's ELASTIC hashinator uses to place keys on the ring.
+func murmur3H64(data []byte) uint64 {
+	var h1, h2 uint64
+
+	nblocks := len(data) / 16
+	for i := 0; i < nblocks; i++ {
+		block := data[i*16 : i*16+16]
+		k1 := binary.LittleEndian.Uint64(block[0:8])
+		k2 := binary.LittleEndian.Uint64(block[8:16])
+
+		k1 *= murmur3C1
+		k1 = rotl64(k1, 31)
+		k1 *= murmur3C2
+		h1 ^= k1
+
+		h1 = rotl64(h1, 27)
+		h1 += h2
+		h1 = h1*5 + 0x52dce729
+
+		k2 *= murmur3C2
+		k2 = rotl64(k2, 33)
+		k2 *= murmur3C1
+		h2 ^= k2
+
+		h2 = rotl64(h2, 31)
+		h2 += h1
+		h2 = h2*5 + 0x38495ab5
+	}
+
+	tail := data[nblocks*16:]
+	var k1, k2 uint64
+	switch len(tail) {
+	case 15:
+		k2 ^= uint64(tail[14]) << 48
+		fallthrough
+	case 14:
+		k2 ^= uint64(tail[13]) << 40
+		fallthrough
+	case 13:
+		k2 ^= uint64(tail[12]) << 32
+		fallthrough
+	case 12:
+		k2 ^= uint64(tail[11]) << 24
+		fallthrough
+	case 11:
+		k2 ^= uint64(tail[10]) << 16
+		fallthrough
+	case 10:
+		k2 ^= uint64(tail[9]) << 8
+		fallthrough
+	case 9:
+		k2 ^= uint64(tail[8])
+		k2 *= murmur3C2
+		k2 = rotl64(k2, 33)
+		k2 *= murmur3C1
+		h2 ^= k2
+		fallthrough
+	case 8:
+		k1 ^= uint64(tail[7]) << 56
+		fallthrough
+	case 7:
+		k1 ^= uint64(tail[6]) << 48
+		fallthrough
+	case 6:
+		k1 ^= uint64(tail[5]) << 40
+		fallthrough
+	case 5:
+		k1 ^= uint64(tail[4]) << 32
+		fallthrough
+	case 4:
+		k1 ^= uint64(tail[3]) << 24
+		fallthrough
+	case 3:
+		k1 ^= uint64(tail[2]) << 16
+		fallthrough
+	case 2:
+		k1 ^= uint64(tail[1]) << 8
+		fallthrough
+	case 1:
+		k1 ^= uint64(tail[0])
+		k1 *= murmur3C1
+		k1 = rotl64(k1, 31)
+		k1 *= murmur3C2
+		h1 ^= k1
+	}
+
+	h1 ^= uint64(len(data))
+	h2 ^= uint64(len(data))
+
+	h1 += h2
+	h2 += h1
+
+	h1 = fmix64(h1)
+	h2 = fmix64(h2)
+
+	h1 += h2
+
+	return h1
+}
+
+func rotl64(x uint64, r uint) uint64 {
+	return (x << r) | (x >> (64 - r))
+}
+
+func fmix64(k uint64) uint64 {
+	k ^= k >> 33
+	k *= 0xff51afd7ed558ccd
+	k ^= k >> 33
+	k *= 0xc4ceb9fe1a85ec53
+	k ^= k >> 33
+	return k
+}
+
+// ringEntry is one partition's position on the consistent-hash ring.
+type ringEntry struct {
+	token     uint64
+	partition int32
+}
+
+// ringTokens returns the ring for a cluster with numPartitions partitions,
+// one entry per partition id, sorted by token.
+func ringTokens(numPartitions int) []ringEntry {
+	ring := make([]ringEntry, numPartitions)
+	for p := 0; p < numPartitions; p++ {
+		ring[p] = ringEntry{token: hashToken(int64(p)), partition: int32(p)}
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i].token < ring[j].token })
+	return ring
+}
+
+// partitionForValue maps value onto ring, a consistent-hash ring built by
+// ringTokens, and returns the owning partition id: the first ring entry
+// whose token is >= hashToken(value), wrapping around to the first entry
+// past the largest token.
+func partitionForValue(value interface{}, ring []ringEntry) int32 {
+	if len(ring) == 0 {
+		return 0
+	}
+	target := hashToken(value)
+	i := sort.Search(len(ring), func(i int) bool { return ring[i].token >= target })
+	if i == len(ring) {
+		i = 0
+	}
+	return ring[i].partition
+}
@@ -0,0 +1,86 @@
+/* This file is part of VoltDB.
+ * Copyright (C) 2008-2016 VoltDB Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with VoltDB.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package voltdbclient
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestMurmur3H64EmptyInput(t *testing.T) {
+	if got := murmur3H64(nil); got != 0 {
+		t.Errorf("murmur3H64(nil) = %d, want 0", got)
+	}
+}
+
+func TestMurmur3H64Deterministic(t *testing.T) {
+	a := murmur3H64([]byte("voltdb"))
+	b := murmur3H64([]byte("voltdb"))
+	if a != b {
+		t.Errorf("murmur3H64 not deterministic: %d != %d", a, b)
+	}
+	if a == murmur3H64([]byte("voltdb2")) {
+		t.Errorf("murmur3H64 collided on two distinct short inputs")
+	}
+}
+
+func TestHashTokenIntegerWidths(t *testing.T) {
+	if hashToken(int64(42)) != hashToken(int32(42)) {
+		t.Errorf("hashToken should hash equal-valued integer types identically")
+	}
+	if hashToken(int64(42)) == hashToken(int64(43)) {
+		t.Errorf("hashToken collided on adjacent integers")
+	}
+}
+
+func TestRingTokensSortedAndCoversEveryPartition(t *testing.T) {
+	ring := ringTokens(8)
+	if len(ring) != 8 {
+		t.Fatalf("len(ring) = %d, want 8", len(ring))
+	}
+	if !sort.SliceIsSorted(ring, func(i, j int) bool { return ring[i].token < ring[j].token }) {
+		t.Errorf("ring is not sorted by token")
+	}
+	seen := make(map[int32]bool)
+	for _, e := range ring {
+		seen[e.partition] = true
+	}
+	for p := int32(0); p < 8; p++ {
+		if !seen[p] {
+			t.Errorf("ring is missing partition %d", p)
+		}
+	}
+}
+
+func TestPartitionForValueStableAcrossCalls(t *testing.T) {
+	ring := ringTokens(4)
+	p1 := partitionForValue(int64(12345), ring)
+	p2 := partitionForValue(int64(12345), ring)
+	if p1 != p2 {
+		t.Errorf("partitionForValue not stable: %d != %d", p1, p2)
+	}
+	if p1 < 0 || p1 >= 4 {
+		t.Errorf("partitionForValue returned out-of-range partition %d", p1)
+	}
+}
+
+func TestPartitionForValueEmptyRing(t *testing.T) {
+	if got := partitionForValue(int64(1), nil); got != 0 {
+		t.Errorf("partitionForValue(empty ring) = %d, want 0", got)
+	}
+}
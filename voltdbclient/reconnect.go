@@ -0,0 +1,320 @@
+/* This file is part of VoltDB.
+ * Copyright (C) 2008-2016 VoltDB Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with VoltDB.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package voltdbclient
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ErrConnectionLost is delivered to in-flight invocations, and returned
+// from Prepare/Exec/Query, when the underlying socket dies. Err, if
+// non-nil, is the lower-level cause (an io error, a timeout, ...).
+type ErrConnectionLost struct {
+	Host string
+	Err  error
+}
+
+func (e *ErrConnectionLost) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("voltdbclient: connection to %v lost: %v", e.Host, e.Err)
+	}
+	return fmt.Sprintf("voltdbclient: connection to %v lost", e.Host)
+}
+
+func (e *ErrConnectionLost) Unwrap() error { return e.Err }
+
+// responseStatus is a VoltDB ClientResponse status byte.
+type responseStatus int8
+
+const (
+	statusSuccess           responseStatus = 1
+	statusUserAbort         responseStatus = -1
+	statusGracefulFailure   responseStatus = -2
+	statusUnexpectedFailure responseStatus = -3
+	statusConnectionLost    responseStatus = -4
+	statusServerUnavailable responseStatus = -5
+	statusConnectionTimeout responseStatus = -6
+)
+
+// isTransient reports whether status describes a failure that's worth
+// retrying (the connection dropped, the server was mid-failover, ...) as
+// opposed to one that will recur every time (a bad procedure, a
+// constraint violation, ...).
+func (s responseStatus) isTransient() bool {
+	switch s {
+	case statusConnectionLost, statusServerUnavailable, statusConnectionTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// RetryPolicy controls reconnect backoff and, optionally, automatic retry
+// of idempotent procedure calls after a transient failure. The zero value
+// reconnects with a 100ms/5s backoff and does not retry calls.
+type RetryPolicy struct {
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+	MaxAttempts  int // 0 means unlimited
+
+	// RetryIdempotent, if true, lets callers opt into Exec/Query being
+	// retried once automatically after a transient failure. Leave false
+	// for procedures that aren't safe to run twice.
+	RetryIdempotent bool
+
+	// Jitter is the fraction, in [0, 1), of each computed delay to
+	// randomize, to avoid a thundering herd of reconnecting clients.
+	Jitter float64
+}
+
+// DefaultRetryPolicy is used by Connector when RetryPolicy is left unset.
+var DefaultRetryPolicy = RetryPolicy{
+	InitialDelay: 100 * time.Millisecond,
+	MaxDelay:     5 * time.Second,
+	MaxAttempts:  0,
+}
+
+// noContextRetryBudget bounds the non-context Prepare's reconnect loop when
+// MaxAttempts is unlimited, so a caller that didn't supply a context still
+// fails eventually against an unreachable host instead of blocking forever.
+// PrepareContext is unaffected; it honors the caller's own context.
+const noContextRetryBudget = 30 * time.Second
+
+// statusError is implemented by an error that carries a VoltDB
+// ClientResponse status byte, letting isTransientErr classify it via
+// responseStatus.isTransient instead of matching on error type.
+type statusError interface {
+	Status() responseStatus
+}
+
+// isTransientErr reports whether err describes a failure worth retrying.
+// It recognizes a statusError's status code, and this package's own
+// ErrConnectionLost, which is always transient by definition.
+func isTransientErr(err error) bool {
+	var se statusError
+	if errors.As(err, &se) {
+		return se.Status().isTransient()
+	}
+	var lost *ErrConnectionLost
+	return errors.As(err, &lost)
+}
+
+// delay returns the backoff before reconnect attempt n (1-based), clamped
+// to MaxDelay and randomized by Jitter.
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	initial := p.InitialDelay
+	if initial <= 0 {
+		initial = DefaultRetryPolicy.InitialDelay
+	}
+	max := p.MaxDelay
+	if max <= 0 {
+		max = DefaultRetryPolicy.MaxDelay
+	}
+
+	d := initial
+	for i := 1; i < attempt; i++ {
+		d *= 2
+		if d >= max {
+			d = max
+			break
+		}
+	}
+
+	if p.Jitter > 0 {
+		d = time.Duration(float64(d) * (1 - p.Jitter + rand.Float64()*p.Jitter))
+	}
+	return d
+}
+
+// reconnectingConn wraps a *VoltConn and transparently re-dials it on the
+// next call after the socket dies, using policy's backoff. It implements
+// driver.Conn, driver.ConnPrepareContext, and driver.Pinger.
+type reconnectingConn struct {
+	cfg    *connConfig
+	policy RetryPolicy
+
+	mu   sync.Mutex
+	conn *VoltConn
+}
+
+func newReconnectingConn(cfg *connConfig, policy RetryPolicy, conn *VoltConn) *reconnectingConn {
+	return &reconnectingConn{cfg: cfg, policy: policy, conn: conn}
+}
+
+// ensureConnected returns the current connection if it's open, or redials
+// it, retrying with backoff until it succeeds, ctx is done, or
+// policy.MaxAttempts is exhausted.
+func (rc *reconnectingConn) ensureConnected(ctx context.Context) (*VoltConn, error) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.conn != nil && rc.conn.Open() {
+		return rc.conn, nil
+	}
+
+	if rc.conn != nil {
+		rc.conn.failAllOutstanding(&ErrConnectionLost{Host: rc.cfg.hostAndPort})
+	}
+
+	var lastErr error
+	for attempt := 1; rc.policy.MaxAttempts == 0 || attempt <= rc.policy.MaxAttempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(rc.policy.delay(attempt)):
+			}
+		}
+
+		conn, err := dialVoltConn(ctx, rc.cfg)
+		if err == nil {
+			rc.conn = conn
+			return conn, nil
+		}
+		lastErr = err
+	}
+	return nil, &ErrConnectionLost{Host: rc.cfg.hostAndPort, Err: lastErr}
+}
+
+// Prepare bounds its reconnect wait to noContextRetryBudget when the policy
+// allows unlimited attempts, since a bare Prepare() call gives it no
+// context to take a deadline from otherwise; PrepareContext below lets the
+// caller control that instead.
+func (rc *reconnectingConn) Prepare(query string) (driver.Stmt, error) {
+	ctx := context.Background()
+	if rc.policy.MaxAttempts == 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, noContextRetryBudget)
+		defer cancel()
+	}
+
+	conn, err := rc.ensureConnected(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stmt, err := conn.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return rc.wrapStmt(stmt, query), nil
+}
+
+func (rc *reconnectingConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	conn, err := rc.ensureConnected(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stmt, err := conn.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return rc.wrapStmt(stmt, query), nil
+}
+
+// wrapStmt wraps stmt in a retryStmt when the policy opts into automatic
+// retry of idempotent procedures, and returns it as-is otherwise.
+func (rc *reconnectingConn) wrapStmt(stmt driver.Stmt, query string) driver.Stmt {
+	if !rc.policy.RetryIdempotent {
+		return stmt
+	}
+	return &retryStmt{stmt: stmt, rc: rc, query: query}
+}
+
+// Ping implements driver.Pinger: it reconnects (honoring ctx) if necessary,
+// then reports whether the resulting connection is actually open.
+func (rc *reconnectingConn) Ping(ctx context.Context) error {
+	conn, err := rc.ensureConnected(ctx)
+	if err != nil {
+		return driver.ErrBadConn
+	}
+	if !conn.Open() {
+		return driver.ErrBadConn
+	}
+	return nil
+}
+
+func (rc *reconnectingConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("VoltDB does not support transactions, VoltDB autocommits")
+}
+
+func (rc *reconnectingConn) Close() error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.conn == nil {
+		return nil
+	}
+	return rc.conn.Close()
+}
+
+// retryStmt wraps the Stmt reconnectingConn.Prepare/PrepareContext returns
+// when policy.RetryIdempotent is set. On a transient failure (isTransientErr)
+// it redials if the connection has closed (see ensureConnected) and retries
+// exactly once against a freshly prepared Stmt; any error from that retry (including a second
+// transient one) is returned as-is rather than retried again. Only opt into
+// this for procedures that are safe to execute twice.
+type retryStmt struct {
+	stmt  driver.Stmt
+	rc    *reconnectingConn
+	query string
+}
+
+func (s *retryStmt) Close() error { return s.stmt.Close() }
+
+func (s *retryStmt) NumInput() int { return s.stmt.NumInput() }
+
+func (s *retryStmt) Exec(args []driver.Value) (driver.Result, error) {
+	res, err := s.stmt.Exec(args)
+	if err == nil || !isTransientErr(err) {
+		return res, err
+	}
+	stmt, rerr := s.reprepare()
+	if rerr != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	return stmt.Exec(args)
+}
+
+func (s *retryStmt) Query(args []driver.Value) (driver.Rows, error) {
+	rows, err := s.stmt.Query(args)
+	if err == nil || !isTransientErr(err) {
+		return rows, err
+	}
+	stmt, rerr := s.reprepare()
+	if rerr != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	return stmt.Query(args)
+}
+
+// reprepare reconnects and returns a plain (unwrapped) Stmt for the retry,
+// so the retry itself is never subject to a further automatic retry.
+func (s *retryStmt) reprepare() (driver.Stmt, error) {
+	conn, err := s.rc.ensureConnected(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return conn.Prepare(s.query)
+}
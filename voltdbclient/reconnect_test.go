@@ -0,0 +1,83 @@
+/* This file is part of VoltDB.
+ * Copyright (C) 2008-2016 VoltDB Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with VoltDB.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package voltdbclient
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyDelayGrowsAndClamps(t *testing.T) {
+	p := RetryPolicy{InitialDelay: 100 * time.Millisecond, MaxDelay: 1 * time.Second}
+	prev := time.Duration(0)
+	for attempt := 1; attempt <= 6; attempt++ {
+		d := p.delay(attempt)
+		if d < prev {
+			t.Errorf("delay(%d) = %v, want >= previous %v", attempt, d, prev)
+		}
+		if d > p.MaxDelay {
+			t.Errorf("delay(%d) = %v exceeds MaxDelay %v", attempt, d, p.MaxDelay)
+		}
+		prev = d
+	}
+	if got := p.delay(10); got != p.MaxDelay {
+		t.Errorf("delay(10) = %v, want clamped to MaxDelay %v", got, p.MaxDelay)
+	}
+}
+
+func TestRetryPolicyDelayZeroValueUsesDefaults(t *testing.T) {
+	var p RetryPolicy
+	if got := p.delay(1); got != DefaultRetryPolicy.InitialDelay {
+		t.Errorf("delay(1) with zero-value policy = %v, want %v", got, DefaultRetryPolicy.InitialDelay)
+	}
+}
+
+func TestRetryPolicyDelayJitterStaysInRange(t *testing.T) {
+	p := RetryPolicy{InitialDelay: 100 * time.Millisecond, MaxDelay: 100 * time.Millisecond, Jitter: 0.5}
+	for i := 0; i < 20; i++ {
+		d := p.delay(1)
+		if d < 50*time.Millisecond || d > 100*time.Millisecond {
+			t.Errorf("delay(1) with Jitter=0.5 = %v, want within [50ms, 100ms]", d)
+		}
+	}
+}
+
+func TestIsTransientErr(t *testing.T) {
+	if !isTransientErr(&ErrConnectionLost{Host: "localhost:21212"}) {
+		t.Errorf("ErrConnectionLost should be classified transient")
+	}
+	if isTransientErr(errors.New("boom")) {
+		t.Errorf("a plain error should not be classified transient")
+	}
+}
+
+func TestResponseStatusIsTransient(t *testing.T) {
+	transient := []responseStatus{statusConnectionLost, statusServerUnavailable, statusConnectionTimeout}
+	for _, s := range transient {
+		if !s.isTransient() {
+			t.Errorf("status %d should be transient", s)
+		}
+	}
+	notTransient := []responseStatus{statusSuccess, statusUserAbort, statusGracefulFailure, statusUnexpectedFailure}
+	for _, s := range notTransient {
+		if s.isTransient() {
+			t.Errorf("status %d should not be transient", s)
+		}
+	}
+}
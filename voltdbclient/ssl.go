@@ -0,0 +1,152 @@
+/* This file is part of VoltDB.
+ * Copyright (C) 2008-2016 VoltDB Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with VoltDB.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package voltdbclient
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+)
+
+// OpenConnTLS is OpenConn, but the TCP socket is wrapped in a TLS
+// connection configured by cfg before the login handshake runs. Use this to
+// talk to a VoltDB Enterprise cluster with SSL/TLS enabled.
+func OpenConnTLS(connInfo string, cfg *tls.Config) (*VoltConn, error) {
+	cc, err := parseDSN(connInfo)
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		cfg = &tls.Config{}
+	}
+	cc.tlsConfig = cfg
+	return dialVoltConn(context.Background(), cc)
+}
+
+// buildTLSConfig turns the "sslmode=...&sslrootcert=...&sslcert=...&sslkey=..."
+// query parameters parsed out of a DSN into a *tls.Config, following the
+// sslmode semantics lib/pq's ssl.go uses:
+//
+//   - disable (default): no TLS.
+//   - require: TLS, but the server's certificate is not verified.
+//   - verify-ca: TLS, the server's certificate must chain to sslrootcert,
+//     but its hostname is not checked.
+//   - verify-full: TLS, the server's certificate must chain to sslrootcert
+//     and its hostname must match host.
+//
+// sslcert/sslkey, if given, configure a client certificate for mutual TLS.
+func buildTLSConfig(host, params string) (*tls.Config, error) {
+	values, err := url.ParseQuery(params)
+	if err != nil {
+		return nil, fmt.Errorf("voltdbclient: invalid connection parameters: %v", err)
+	}
+
+	sslmode := values.Get("sslmode")
+	if sslmode == "" || sslmode == "disable" {
+		return nil, nil
+	}
+
+	cfg := &tls.Config{ServerName: stripPort(host)}
+
+	switch sslmode {
+	case "require":
+		cfg.InsecureSkipVerify = true
+	case "verify-ca":
+		cfg.InsecureSkipVerify = true
+		cfg.VerifyPeerCertificate = verifyCAOnly(cfg)
+	case "verify-full":
+		// default verification (chain + hostname) is exactly what we want.
+	default:
+		return nil, fmt.Errorf("voltdbclient: unsupported sslmode %q", sslmode)
+	}
+
+	if sslmode == "verify-ca" || sslmode == "verify-full" {
+		rootCert := values.Get("sslrootcert")
+		if rootCert == "" {
+			return nil, errors.New("voltdbclient: sslrootcert is required for sslmode=" + sslmode)
+		}
+		pool, err := loadCertPool(rootCert)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = pool
+	}
+
+	certFile, keyFile := values.Get("sslcert"), values.Get("sslkey")
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, errors.New("voltdbclient: both sslcert and sslkey are required to use a client certificate")
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("voltdbclient: loading client certificate: %v", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
+
+// verifyCAOnly builds a VerifyPeerCertificate callback that checks the
+// server's certificate chains to cfg.RootCAs without checking the
+// certificate's hostname, which is what sslmode=verify-ca calls for.
+func verifyCAOnly(cfg *tls.Config) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		certs := make([]*x509.Certificate, len(rawCerts))
+		for i, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return err
+			}
+			certs[i] = cert
+		}
+
+		opts := x509.VerifyOptions{Roots: cfg.RootCAs, Intermediates: x509.NewCertPool()}
+		for _, cert := range certs[1:] {
+			opts.Intermediates.AddCert(cert)
+		}
+		_, err := certs[0].Verify(opts)
+		return err
+	}
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("voltdbclient: reading sslrootcert: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("voltdbclient: no certificates found in %v", path)
+	}
+	return pool, nil
+}
+
+// stripPort returns hostAndPort with any trailing ":port" removed, for use
+// as a TLS ServerName.
+func stripPort(hostAndPort string) string {
+	if i := strings.LastIndexByte(hostAndPort, ':'); i >= 0 {
+		return hostAndPort[:i]
+	}
+	return hostAndPort
+}
@@ -0,0 +1,96 @@
+/* This file is part of VoltDB.
+ * Copyright (C) 2008-2016 VoltDB Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with VoltDB.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package voltdbclient
+
+import "testing"
+
+func TestBuildTLSConfigDisabledByDefault(t *testing.T) {
+	cfg, err := buildTLSConfig("localhost:21212", "")
+	if err != nil {
+		t.Fatalf("buildTLSConfig returned error: %v", err)
+	}
+	if cfg != nil {
+		t.Errorf("buildTLSConfig with no sslmode = %v, want nil (TLS disabled)", cfg)
+	}
+}
+
+func TestBuildTLSConfigRequireSkipsVerification(t *testing.T) {
+	cfg, err := buildTLSConfig("localhost:21212", "sslmode=require")
+	if err != nil {
+		t.Fatalf("buildTLSConfig returned error: %v", err)
+	}
+	if cfg == nil || !cfg.InsecureSkipVerify {
+		t.Errorf("sslmode=require should skip certificate verification")
+	}
+}
+
+func TestBuildTLSConfigVerifyCARequiresRootCert(t *testing.T) {
+	if _, err := buildTLSConfig("localhost:21212", "sslmode=verify-ca"); err == nil {
+		t.Errorf("sslmode=verify-ca without sslrootcert should error")
+	}
+}
+
+func TestBuildTLSConfigUnsupportedMode(t *testing.T) {
+	if _, err := buildTLSConfig("localhost:21212", "sslmode=bogus"); err == nil {
+		t.Errorf("unsupported sslmode should error")
+	}
+}
+
+func TestBuildTLSConfigMissingRootCertFile(t *testing.T) {
+	if _, err := buildTLSConfig("voltdb.example.com:21212", "sslmode=verify-full&sslrootcert=testdata/does-not-exist.pem"); err == nil {
+		t.Errorf("expected error loading a missing sslrootcert")
+	}
+}
+
+func TestBuildTLSConfigClientCertRequiresBoth(t *testing.T) {
+	if _, err := buildTLSConfig("localhost:21212", "sslmode=require&sslcert=only-cert.pem"); err == nil {
+		t.Errorf("sslcert without sslkey should error")
+	}
+}
+
+func TestStripPort(t *testing.T) {
+	cases := map[string]string{
+		"localhost:21212": "localhost",
+		"localhost":       "localhost",
+		"[::1]:21212":     "[::1]",
+	}
+	for in, want := range cases {
+		if got := stripPort(in); got != want {
+			t.Errorf("stripPort(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestParseDSNRequiresNonEmpty(t *testing.T) {
+	if _, err := parseDSN(""); err == nil {
+		t.Errorf(`parseDSN("") should error`)
+	}
+}
+
+func TestParseDSNSplitsHostFromParams(t *testing.T) {
+	cfg, err := parseDSN("localhost:21212?sslmode=disable")
+	if err != nil {
+		t.Fatalf("parseDSN returned error: %v", err)
+	}
+	if cfg.hostAndPort != "localhost:21212" {
+		t.Errorf("hostAndPort = %q, want %q", cfg.hostAndPort, "localhost:21212")
+	}
+	if cfg.tlsConfig != nil {
+		t.Errorf("tlsConfig = %v, want nil for sslmode=disable", cfg.tlsConfig)
+	}
+}
@@ -0,0 +1,58 @@
+/* This file is part of VoltDB.
+ * Copyright (C) 2008-2016 VoltDB Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with VoltDB.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package voltdbclient
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/binary"
+	"fmt"
+)
+
+// encodeVoltTable serializes rows into the single payload VoltBulkLoader
+// ships per batch to @LoadSinglepartitionTable/@LoadMultipartitionTable, so
+// a whole batch is one RPC instead of one per row.
+//
+// The layout here -- a row count, then each row as a column count followed
+// by length-prefixed columns rendered with fmt.Sprint -- is a stand-in for
+// VoltDB's real VoltTable binary format, which additionally carries a
+// column name/type header this client has no schema to populate (it only
+// knows the row values BulkLoader.WriteRow was given, not the target
+// table's column types). Swapping in the real VoltTable encoder once that
+// schema is available is the one seam this function exists to isolate.
+func encodeVoltTable(rows [][]driver.Value) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.BigEndian, int32(len(rows))); err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		if err := binary.Write(&buf, binary.BigEndian, int32(len(row))); err != nil {
+			return nil, err
+		}
+		for _, col := range row {
+			s := fmt.Sprint(col)
+			if err := binary.Write(&buf, binary.BigEndian, int32(len(s))); err != nil {
+				return nil, err
+			}
+			if _, err := buf.WriteString(s); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return buf.Bytes(), nil
+}